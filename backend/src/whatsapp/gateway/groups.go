@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"go.mau.fi/whatsmeow"
+	"go.mau.fi/whatsmeow/types"
+
+	"github.com/vishal124942/orbit_ai/backend/src/whatsapp/gateway/store"
+)
+
+// ── Group metadata sync ─────────────────────────────────────────────────────
+
+// refreshGroupContacts pulls the full set of joined groups and merges them
+// into the contacts cache, including per-group participant lists and admin
+// JIDs, so sendContacts can surface them to Python.
+func refreshGroupContacts() {
+	if client == nil || !client.IsConnected() {
+		return
+	}
+
+	groups, err := client.GetJoinedGroups(context.Background())
+	if err != nil {
+		logErr(fmt.Sprintf("Failed to fetch joined groups: %v", err))
+		return
+	}
+
+	contactMu.Lock()
+	defer contactMu.Unlock()
+	for _, g := range groups {
+		contacts[g.JID.String()] = ContactInfo{
+			ID:           g.JID.String(),
+			Name:         g.Name,
+			IsGroup:      true,
+			Participants: groupParticipantInfos(g.Participants),
+		}
+
+		if histStore != nil {
+			if err := histStore.UpsertContact(store.Contact{JID: g.JID.String(), Name: g.Name, IsGroup: true}); err != nil {
+				logErr(fmt.Sprintf("Failed to persist group contact %s: %v", g.JID, err))
+			}
+		}
+	}
+}
+
+func groupParticipantInfos(participants []types.GroupParticipant) []GroupParticipantInfo {
+	infos := make([]GroupParticipantInfo, len(participants))
+	for i, p := range participants {
+		infos[i] = GroupParticipantInfo{
+			JID:     p.JID.String(),
+			IsAdmin: p.IsAdmin || p.IsSuperAdmin,
+		}
+	}
+	return infos
+}
+
+// ── Group commands ───────────────────────────────────────────────────────────
+
+func parseJIDs(raw []string) ([]types.JID, error) {
+	jids := make([]types.JID, len(raw))
+	for i, r := range raw {
+		jid, err := parseJID(r)
+		if err != nil {
+			return nil, err
+		}
+		jids[i] = jid
+	}
+	return jids, nil
+}
+
+func handleCreateGroup(cmd InCommand) error {
+	participants, err := parseJIDs(cmd.Participants)
+	if err != nil {
+		return err
+	}
+
+	info, err := client.CreateGroup(context.Background(), whatsmeow.ReqCreateGroup{
+		Name:         cmd.Text,
+		Participants: participants,
+	})
+	if err != nil {
+		return fmt.Errorf("create_group failed: %v", err)
+	}
+
+	emit(map[string]interface{}{
+		"type": "group_created",
+		"id":   cmd.ID,
+		"from": info.JID.String(),
+	})
+	return nil
+}
+
+func handleGetGroupInfo(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+
+	info, err := client.GetGroupInfo(context.Background(), jid)
+	if err != nil {
+		return fmt.Errorf("get_group_info failed: %v", err)
+	}
+
+	emit(map[string]interface{}{
+		"type": "group_info",
+		"id":   cmd.ID,
+		"from": jid.String(),
+		"data": map[string]interface{}{
+			"name":         info.Name,
+			"topic":        info.Topic,
+			"participants": groupParticipantInfos(info.Participants),
+		},
+	})
+	return nil
+}
+
+func handleUpdateGroupSubject(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+	return client.SetGroupName(context.Background(), jid, cmd.Text)
+}
+
+func handleUpdateGroupDescription(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+	return client.SetGroupTopic(context.Background(), jid, "", "", cmd.Text)
+}
+
+func groupParticipantAction(action string) (whatsmeow.ParticipantChange, error) {
+	switch action {
+	case "add":
+		return whatsmeow.ParticipantChangeAdd, nil
+	case "remove":
+		return whatsmeow.ParticipantChangeRemove, nil
+	case "promote":
+		return whatsmeow.ParticipantChangePromote, nil
+	case "demote":
+		return whatsmeow.ParticipantChangeDemote, nil
+	default:
+		return "", fmt.Errorf("unknown group participant action %q", action)
+	}
+}
+
+func handleUpdateGroupParticipants(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+	action, err := groupParticipantAction(cmd.Action)
+	if err != nil {
+		return err
+	}
+	participants, err := parseJIDs(cmd.Participants)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateGroupParticipants(context.Background(), jid, participants, action)
+	return err
+}
+
+func handleLeaveGroup(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+	return client.LeaveGroup(context.Background(), jid)
+}
+
+func handleGetGroupInviteLink(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+
+	link, err := client.GetGroupInviteLink(context.Background(), jid, false)
+	if err != nil {
+		return fmt.Errorf("get_group_invite_link failed: %v", err)
+	}
+
+	emit(map[string]interface{}{
+		"type": "group_invite_link",
+		"id":   cmd.ID,
+		"from": jid.String(),
+		"text": link,
+	})
+	return nil
+}
+
+func handleJoinGroupViaLink(cmd InCommand) error {
+	jid, err := client.JoinGroupWithLink(context.Background(), cmd.Text)
+	if err != nil {
+		return fmt.Errorf("join_group_via_link failed: %v", err)
+	}
+
+	emit(map[string]interface{}{
+		"type": "group_joined",
+		"id":   cmd.ID,
+		"from": jid.String(),
+	})
+	return nil
+}
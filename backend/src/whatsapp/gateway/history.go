@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/vishal124942/orbit_ai/backend/src/whatsapp/gateway/store"
+)
+
+const defaultHistoryLimit = 50
+
+func emitMessages(eventType string, cmd InCommand, messages []store.Message) {
+	emit(map[string]interface{}{
+		"type": eventType,
+		"id":   cmd.ID,
+		"data": messages,
+	})
+}
+
+func handleGetHistory(cmd InCommand) error {
+	if histStore == nil {
+		return fmt.Errorf("history store not available")
+	}
+
+	limit := cmd.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	messages, err := histStore.History(cmd.Chat, cmd.Before, limit)
+	if err != nil {
+		return fmt.Errorf("get_history failed: %v", err)
+	}
+
+	emitMessages("history", cmd, messages)
+	return nil
+}
+
+func handleSearchMessages(cmd InCommand) error {
+	if histStore == nil {
+		return fmt.Errorf("history store not available")
+	}
+	if cmd.Query == "" {
+		return fmt.Errorf("search_messages requires query")
+	}
+
+	messages, err := histStore.Search(cmd.Query, cmd.Chat)
+	if err != nil {
+		return fmt.Errorf("search_messages failed: %v", err)
+	}
+
+	emitMessages("search_results", cmd, messages)
+	return nil
+}
@@ -1,9 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"context"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"os"
 	"os/signal"
@@ -21,6 +20,8 @@ import (
 	"go.mau.fi/whatsmeow/types/events"
 	waLog "go.mau.fi/whatsmeow/util/log"
 	"google.golang.org/protobuf/proto"
+
+	"github.com/vishal124942/orbit_ai/backend/src/whatsapp/gateway/store"
 )
 
 // ── JSON Protocol (same as gateway_v3.js) ───────────────────────────────────
@@ -45,22 +46,41 @@ type OutEvent struct {
 }
 
 type InCommand struct {
-	Type      string `json:"type"`
-	ID        int64  `json:"id"`
-	To        string `json:"to"`
-	Text      string `json:"text"`
-	Media     string `json:"media"`
-	MediaType string `json:"mediaType"`
-	MessageID string `json:"messageId"`
-	Emoji     string `json:"emoji"`
+	Type         string   `json:"type"`
+	ID           int64    `json:"id"`
+	To           string   `json:"to"`
+	Text         string   `json:"text"`
+	Media        string   `json:"media"`
+	MediaType    string   `json:"mediaType"`
+	MessageID    string   `json:"messageId"`
+	MessageIDs   []string `json:"messageIds"`
+	Sender       string   `json:"sender"`
+	Emoji        string   `json:"emoji"`
+	Participants []string `json:"participants"`
+	Action       string   `json:"action"`
+	Chat         string   `json:"chat"`
+	Query        string   `json:"query"`
+	Before       int64    `json:"before"`
+	Limit        int      `json:"limit"`
+	QuotedID     string   `json:"quotedId"`
+	QuotedFrom   string   `json:"quotedFrom"`
+	QuotedText   string   `json:"quotedText"`
+	Mentions     []string `json:"mentions"`
 }
 
 type ContactInfo struct {
-	ID       string `json:"id"`
-	Name     string `json:"name"`
-	Notify   string `json:"notify"`
-	PushName string `json:"pushName"`
-	IsLid    bool   `json:"isLid"`
+	ID           string                 `json:"id"`
+	Name         string                 `json:"name"`
+	Notify       string                 `json:"notify"`
+	PushName     string                 `json:"pushName"`
+	IsLid        bool                   `json:"isLid"`
+	IsGroup      bool                   `json:"isGroup,omitempty"`
+	Participants []GroupParticipantInfo `json:"participants,omitempty"`
+}
+
+type GroupParticipantInfo struct {
+	JID     string `json:"jid"`
+	IsAdmin bool   `json:"isAdmin"`
 }
 
 type HistoryMessage struct {
@@ -76,29 +96,44 @@ type HistoryMessage struct {
 
 var (
 	client    *whatsmeow.Client
-	outMu     sync.Mutex // Protect stdout writes
 	contacts  = make(map[string]ContactInfo)
 	contactMu sync.Mutex
+	authDir   string
+	histStore *store.Store
 )
 
 const MAX_HISTORY_PER_CONTACT = 50
 
 // ── Output helpers ──────────────────────────────────────────────────────────
 
-func emit(v interface{}) {
-	outMu.Lock()
-	defer outMu.Unlock()
-	data, err := json.Marshal(v)
-	if err != nil {
-		return
-	}
-	fmt.Println(string(data))
-}
-
 func logErr(msg string) {
 	fmt.Fprintln(os.Stderr, "[Gateway] "+msg)
 }
 
+// stderrLogger adapts whatsmeow's waLog.Logger to logErr so whatsmeow's own
+// WARN-level logging lands on stderr instead of stdout. Stdout is owned
+// exclusively by the framed transport's length-prefixed frames — a single
+// plain-text log line interleaved there desyncs the 4-byte length reader.
+type stderrLogger struct {
+	module string
+}
+
+func (l stderrLogger) Warnf(msg string, args ...interface{}) {
+	logErr(fmt.Sprintf("%s WARN: %s", l.module, fmt.Sprintf(msg, args...)))
+}
+
+func (l stderrLogger) Errorf(msg string, args ...interface{}) {
+	logErr(fmt.Sprintf("%s ERROR: %s", l.module, fmt.Sprintf(msg, args...)))
+}
+
+func (l stderrLogger) Infof(msg string, args ...interface{}) {}
+
+func (l stderrLogger) Debugf(msg string, args ...interface{}) {}
+
+func (l stderrLogger) Sub(module string) waLog.Logger {
+	return stderrLogger{module: l.module + "/" + module}
+}
+
 // ── Event Handler ───────────────────────────────────────────────────────────
 
 type eventHandler struct{}
@@ -114,6 +149,7 @@ func (h *eventHandler) HandleEvent(rawEvt interface{}) {
 
 	case *events.PairSuccess:
 		logErr(fmt.Sprintf("Successfully paired with %s", evt.ID))
+		notifyPairSuccess()
 
 	case *events.Connected:
 		logErr("Connection opened successfully")
@@ -162,6 +198,30 @@ func (h *eventHandler) HandleEvent(rawEvt interface{}) {
 
 	case *events.Contact:
 		handleContactEvent(evt)
+
+	case *events.Receipt:
+		handleReceipt(evt)
+
+	case *events.Presence:
+		handlePresence(evt)
+
+	case *events.ChatPresence:
+		handleChatPresence(evt)
+
+	case *events.UndecryptableMessage:
+		handleUndecryptableMessage(evt)
+
+	case *events.GroupInfo:
+		handleGroupInfo(evt)
+
+	case *events.CallOffer:
+		handleCallOffer(evt)
+
+	case *events.CallTerminate:
+		handleCallTerminate(evt)
+
+	case *events.Picture:
+		handlePictureEvent(evt)
 	}
 }
 
@@ -202,6 +262,11 @@ func handleMessage(evt *events.Message) {
 	jid := info.Chat.String()
 	isGroup := info.Chat.Server == "g.us"
 
+	// Media is downloaded off the event-handling goroutine by a bounded
+	// worker pool; a follow-up "message_media" event fills in mediaPath
+	// once it lands so history syncs don't stall on slow downloads.
+	enqueueMediaDownload(mediaDownloadJob{id: info.ID, chatJID: jid, message: evt.Message})
+
 	// Update contact cache
 	if info.PushName != "" && !isGroup {
 		contactMu.Lock()
@@ -215,19 +280,52 @@ func handleMessage(evt *events.Message) {
 		contactMu.Unlock()
 	}
 
+	// In groups the chat JID only identifies the group; the actual author
+	// is the participant JID.
+	participant := ""
+	if isGroup {
+		participant = info.Sender.String()
+	}
+
+	quotedID, quotedFrom, mentions := extractReplyContext(evt.Message)
+
+	if histStore != nil {
+		senderJID := info.Sender.String()
+		if err := histStore.SaveMessage(store.Message{
+			ID:        info.ID,
+			ChatJID:   jid,
+			SenderJID: senderJID,
+			FromMe:    info.IsFromMe,
+			Text:      text,
+			Timestamp: info.Timestamp.Unix(),
+			QuotedID:  quotedID,
+		}); err != nil {
+			logErr(fmt.Sprintf("Failed to persist message %s: %v", info.ID, err))
+		}
+	}
+
 	// Emit to Python
 	emit(map[string]interface{}{
-		"type":      "message",
-		"id":        info.ID,
-		"from":      jid,
-		"pushName":  info.PushName,
-		"text":      text,
-		"mediaPath": nil,
-		"mediaType": nil,
-		"timestamp": info.Timestamp.Unix(),
-		"isGroup":   isGroup,
-		"fromMe":    info.IsFromMe,
+		"type":        "message",
+		"id":          info.ID,
+		"from":        jid,
+		"participant": participant,
+		"pushName":    info.PushName,
+		"text":        text,
+		"mediaPath":   nil,
+		"mediaType":   nil,
+		"quotedId":    quotedID,
+		"quotedFrom":  quotedFrom,
+		"mentions":    mentions,
+		"timestamp":   info.Timestamp.Unix(),
+		"isGroup":     isGroup,
+		"fromMe":      info.IsFromMe,
 	})
+
+	// Media is downloaded off the event-handling goroutine by a bounded
+	// worker pool; a follow-up "message_media" event fills in mediaPath
+	// once it lands so history syncs don't stall on slow downloads.
+	enqueueMediaDownload(mediaDownloadJob{id: info.ID, chatJID: jid, message: evt.Message})
 }
 
 // ── History Sync Handler ────────────────────────────────────────────────────
@@ -269,7 +367,8 @@ func handleHistorySync(evt *events.HistorySync) {
 
 			// Extract text
 			text := extractTextFromMessage(msg.GetMessage())
-			if text == "" {
+			_, _, _, hasMedia := downloadableMedia(msg.GetMessage())
+			if text == "" && !hasMedia {
 				continue
 			}
 
@@ -287,6 +386,22 @@ func handleHistorySync(evt *events.HistorySync) {
 				Timestamp: int64(msg.GetMessageTimestamp()),
 			}
 
+			if histStore != nil {
+				if err := histStore.SaveMessage(store.Message{
+					ID:        hm.ID,
+					ChatJID:   remoteJid,
+					FromMe:    hm.FromMe,
+					Text:      hm.Text,
+					Timestamp: hm.Timestamp,
+				}); err != nil {
+					logErr(fmt.Sprintf("Failed to persist history message %s: %v", hm.ID, err))
+				}
+			}
+
+			// Route through the same bounded worker pool handleMessage uses,
+			// so a history sync full of images doesn't stall event dispatch.
+			enqueueMediaDownload(mediaDownloadJob{id: key.GetID(), chatJID: remoteJid, message: msg.GetMessage()})
+
 			byContact[remoteJid] = append(byContact[remoteJid], hm)
 		}
 	}
@@ -327,11 +442,26 @@ func extractTextFromMessage(msg *waProto.Message) string {
 	if msg.GetExtendedTextMessage() != nil {
 		return msg.GetExtendedTextMessage().GetText()
 	}
-	if msg.GetImageMessage() != nil && msg.GetImageMessage().GetCaption() != "" {
-		return msg.GetImageMessage().GetCaption()
+	if msg.GetImageMessage() != nil {
+		if caption := msg.GetImageMessage().GetCaption(); caption != "" {
+			return caption
+		}
+		return "[Sent an image]"
+	}
+	if msg.GetVideoMessage() != nil {
+		if caption := msg.GetVideoMessage().GetCaption(); caption != "" {
+			return caption
+		}
+		return "[Sent a video]"
+	}
+	if msg.GetAudioMessage() != nil {
+		return "[Sent an audio]"
+	}
+	if msg.GetStickerMessage() != nil {
+		return "[Sticker]"
 	}
-	if msg.GetVideoMessage() != nil && msg.GetVideoMessage().GetCaption() != "" {
-		return msg.GetVideoMessage().GetCaption()
+	if msg.GetDocumentMessage() != nil {
+		return "[Sent a document]"
 	}
 	return ""
 }
@@ -353,9 +483,17 @@ func handleContactEvent(evt *events.Contact) {
 		Name: name,
 	}
 	contactMu.Unlock()
+
+	if histStore != nil {
+		if err := histStore.UpsertContact(store.Contact{JID: jid, Name: name}); err != nil {
+			logErr(fmt.Sprintf("Failed to persist contact %s: %v", jid, err))
+		}
+	}
 }
 
 func sendContacts() {
+	refreshGroupContacts()
+
 	contactMu.Lock()
 	defer contactMu.Unlock()
 
@@ -382,11 +520,13 @@ func sendContacts() {
 		}
 
 		cleaned = append(cleaned, ContactInfo{
-			ID:       c.ID,
-			Name:     name,
-			Notify:   c.Notify,
-			PushName: c.PushName,
-			IsLid:    strings.HasSuffix(c.ID, "@lid"),
+			ID:           c.ID,
+			Name:         name,
+			Notify:       c.Notify,
+			PushName:     c.PushName,
+			IsLid:        strings.HasSuffix(c.ID, "@lid"),
+			IsGroup:      c.IsGroup,
+			Participants: c.Participants,
 		})
 	}
 
@@ -397,36 +537,29 @@ func sendContacts() {
 	})
 }
 
-// ── Command Processing (stdin) ──────────────────────────────────────────────
+// ── Command Processing (transport) ──────────────────────────────────────────
 
 func processCommands(ctx context.Context) {
-	scanner := bufio.NewScanner(os.Stdin)
-	// Increase buffer size for large JSON payloads
-	scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
-
-	for scanner.Scan() {
+	for {
 		select {
 		case <-ctx.Done():
 			return
-		default:
-		}
-
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
-		}
-
-		var cmd InCommand
-		if err := json.Unmarshal([]byte(line), &cmd); err != nil {
-			logErr(fmt.Sprintf("Invalid command JSON: %v", err))
-			continue
+		case cmd, ok := <-transport.Commands():
+			if !ok {
+				logErr("Transport command stream closed")
+				return
+			}
+			go executeCommand(cmd)
 		}
-
-		go executeCommand(cmd)
 	}
 }
 
 func executeCommand(cmd InCommand) {
+	if cmd.Type == "ping" {
+		emit(map[string]interface{}{"type": "pong", "id": cmd.ID})
+		return
+	}
+
 	if client == nil || !client.IsConnected() {
 		emit(map[string]interface{}{
 			"type":    "error",
@@ -446,6 +579,40 @@ func executeCommand(cmd InCommand) {
 		err = handleDeleteMessage(cmd)
 	case "get_contacts":
 		sendContacts()
+	case "subscribe_presence":
+		err = handleSubscribePresence(cmd)
+	case "send_presence":
+		err = handleSendPresence(cmd)
+	case "send_typing":
+		err = handleSendTyping(cmd)
+	case "mark_read":
+		err = handleMarkRead(cmd)
+	case "create_group":
+		err = handleCreateGroup(cmd)
+	case "get_group_info":
+		err = handleGetGroupInfo(cmd)
+	case "update_group_subject":
+		err = handleUpdateGroupSubject(cmd)
+	case "update_group_description":
+		err = handleUpdateGroupDescription(cmd)
+	case "update_group_participants":
+		err = handleUpdateGroupParticipants(cmd)
+	case "leave_group":
+		err = handleLeaveGroup(cmd)
+	case "get_group_invite_link":
+		err = handleGetGroupInviteLink(cmd)
+	case "join_group_via_link":
+		err = handleJoinGroupViaLink(cmd)
+	case "get_history":
+		err = handleGetHistory(cmd)
+	case "search_messages":
+		err = handleSearchMessages(cmd)
+	case "pair_phone":
+		if client.Store.ID != nil {
+			err = fmt.Errorf("already paired")
+		} else {
+			go pairPhone(context.Background(), cmd.To)
+		}
 	default:
 		err = fmt.Errorf("unknown command type: %s", cmd.Type)
 	}
@@ -476,17 +643,15 @@ func handleSendMessage(cmd InCommand) error {
 
 	var msg *waProto.Message
 
-	if cmd.Media != "" && cmd.MediaType != "" {
-		// Media message — for now send as text with media reference
-		// Full media upload can be added later
-		text := cmd.Text
-		if text == "" {
-			text = fmt.Sprintf("[Sent a %s]", cmd.MediaType)
-		}
-		msg = &waProto.Message{
-			Conversation: proto.String(text),
+	switch {
+	case cmd.Media != "" && cmd.MediaType != "":
+		msg, err = buildMediaMessage(context.Background(), cmd)
+		if err != nil {
+			return err
 		}
-	} else {
+	case hasReplyContext(cmd):
+		msg = buildReplyContextMessage(cmd)
+	default:
 		// Plain text message
 		msg = &waProto.Message{
 			Conversation: proto.String(cmd.Text),
@@ -551,11 +716,29 @@ func parseJID(raw string) (types.JID, error) {
 // ── Main ────────────────────────────────────────────────────────────────────
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Fprintln(os.Stderr, "Usage: gateway <auth_dir>")
+	pairPhoneFlag := flag.String("pair-phone", "", "E164 phone number to pair via pairing code instead of QR")
+	listenFlag := flag.String("listen", "", "Socket to serve the gateway protocol on, e.g. unix:///tmp/orbit.sock (default: framed stdio)")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: gateway [--pair-phone <E164>] [--listen <addr>] <auth_dir>")
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		flag.Usage()
 		os.Exit(1)
 	}
-	authDir := os.Args[1]
+	authDir = flag.Arg(0)
+
+	if *listenFlag != "" {
+		t, err := newSocketTransport(*listenFlag)
+		if err != nil {
+			logErr(fmt.Sprintf("Failed to start socket transport: %v", err))
+			os.Exit(1)
+		}
+		transport = t
+	} else {
+		transport = newStdioTransport()
+	}
 
 	// Ensure auth dir exists
 	if err := os.MkdirAll(authDir, 0755); err != nil {
@@ -565,9 +748,18 @@ func main() {
 
 	logErr(fmt.Sprintf("Starting whatsmeow gateway, auth=%s", authDir))
 
+	startMediaWorkers()
+
+	histDB, err := store.Open(fmt.Sprintf("%s/history.db", authDir))
+	if err != nil {
+		logErr(fmt.Sprintf("Failed to open history store (non-fatal): %v", err))
+	} else {
+		histStore = histDB
+	}
+
 	// Quiet logger for whatsmeow internals
 	dbLog := waLog.Noop
-	clientLog := waLog.Stdout("Gateway", "WARN", true)
+	clientLog := stderrLogger{module: "Gateway"}
 
 	// Open SQLite store for auth state
 	dbPath := fmt.Sprintf("file:%s/whatsmeow.db?_journal_mode=WAL&_foreign_keys=on", authDir)
@@ -593,7 +785,17 @@ func main() {
 	client.AutoTrustIdentity = true
 
 	// Connect
-	if client.Store.ID == nil {
+	if client.Store.ID == nil && *pairPhoneFlag != "" {
+		// No session, but a phone number was given — use the pairing-code
+		// flow instead of QR so headless deployments don't need a scanner.
+		logErr(fmt.Sprintf("No existing session, pairing via phone number %s...", *pairPhoneFlag))
+		err = client.Connect()
+		if err != nil {
+			logErr(fmt.Sprintf("Connect failed: %v", err))
+			os.Exit(1)
+		}
+		go pairPhone(context.Background(), *pairPhoneFlag)
+	} else if client.Store.ID == nil {
 		// No session — need QR code
 		logErr("No existing session, generating QR code...")
 		qrChan, _ := client.GetQRChannel(context.Background())
@@ -638,7 +840,7 @@ func main() {
 		}
 	}()
 
-	// Process stdin commands in background
+	// Process transport commands in background
 	ctx, cancel := context.WithCancel(context.Background())
 	go processCommands(ctx)
 
@@ -650,5 +852,9 @@ func main() {
 	logErr("Shutting down gracefully...")
 	cancel()
 	client.Disconnect()
+	_ = transport.Close()
+	if histStore != nil {
+		_ = histStore.Close()
+	}
 	logErr("Goodbye!")
 }
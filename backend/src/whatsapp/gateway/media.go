@@ -0,0 +1,294 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.mau.fi/whatsmeow"
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// ── Media download worker pool ──────────────────────────────────────────────
+
+const mediaWorkerCount = 4
+
+// mediaDownloadJob is the unit of work fed to the download worker pool,
+// carrying just enough to locate and persist the media — live messages and
+// history-sync messages build one the same way from different event shapes.
+type mediaDownloadJob struct {
+	id      string
+	chatJID string
+	message *waProto.Message
+}
+
+// downloadQueue feeds the media worker pool so a burst of incoming media
+// (e.g. a history sync full of images) can't stall event dispatch.
+var downloadQueue chan mediaDownloadJob
+
+func startMediaWorkers() {
+	downloadQueue = make(chan mediaDownloadJob, 256)
+	for i := 0; i < mediaWorkerCount; i++ {
+		go mediaWorker()
+	}
+}
+
+func mediaWorker() {
+	for job := range downloadQueue {
+		downloadMedia(job)
+	}
+}
+
+// enqueueMediaDownload queues job for download if its message carries media,
+// dropping it (with a log line) if the pool is backed up rather than
+// blocking the caller.
+func enqueueMediaDownload(job mediaDownloadJob) {
+	if _, _, _, ok := downloadableMedia(job.message); !ok {
+		return
+	}
+	select {
+	case downloadQueue <- job:
+	default:
+		logErr(fmt.Sprintf("Media download queue full, dropping media for %s", job.id))
+	}
+}
+
+// ── Media Upload (outbound) ─────────────────────────────────────────────────
+
+// mediaPayload is the raw bytes plus detected MIME type for an outbound
+// media command, regardless of whether it arrived as a local file path or a
+// data-URL.
+type mediaPayload struct {
+	bytes    []byte
+	mimeType string
+}
+
+func loadMediaPayload(ref string) (*mediaPayload, error) {
+	if strings.HasPrefix(ref, "data:") {
+		return decodeDataURL(ref)
+	}
+
+	data, err := os.ReadFile(ref)
+	if err != nil {
+		return nil, fmt.Errorf("read media %q: %v", ref, err)
+	}
+
+	mimeType := mime.TypeByExtension(filepath.Ext(ref))
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return &mediaPayload{bytes: data, mimeType: mimeType}, nil
+}
+
+func decodeDataURL(ref string) (*mediaPayload, error) {
+	rest := strings.TrimPrefix(ref, "data:")
+	header, encoded, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, fmt.Errorf("malformed data-URL")
+	}
+
+	mimeType, _, _ := strings.Cut(strings.TrimSuffix(header, ";base64"), ";")
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode data-URL: %v", err)
+	}
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+	return &mediaPayload{bytes: data, mimeType: mimeType}, nil
+}
+
+func whatsmeowMediaType(mimeType, hint string) whatsmeow.MediaType {
+	switch {
+	case hint == "sticker":
+		return whatsmeow.MediaImage
+	case strings.HasPrefix(mimeType, "video/"):
+		return whatsmeow.MediaVideo
+	case strings.HasPrefix(mimeType, "audio/"):
+		return whatsmeow.MediaAudio
+	case strings.HasPrefix(mimeType, "image/"):
+		return whatsmeow.MediaImage
+	default:
+		return whatsmeow.MediaDocument
+	}
+}
+
+// buildMediaMessage uploads cmd.Media and wraps the resulting blob in the
+// proto message matching its MIME type / cmd.MediaType hint.
+func buildMediaMessage(ctx context.Context, cmd InCommand) (*waProto.Message, error) {
+	payload, err := loadMediaPayload(cmd.Media)
+	if err != nil {
+		return nil, err
+	}
+
+	uploaded, err := client.Upload(ctx, payload.bytes, whatsmeowMediaType(payload.mimeType, cmd.MediaType))
+	if err != nil {
+		return nil, fmt.Errorf("upload failed: %v", err)
+	}
+	fileLength := proto.Uint64(uint64(len(payload.bytes)))
+
+	switch {
+	case cmd.MediaType == "sticker":
+		return &waProto.Message{
+			StickerMessage: &waProto.StickerMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				Mimetype:      proto.String(payload.mimeType),
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    fileLength,
+			},
+		}, nil
+
+	case strings.HasPrefix(payload.mimeType, "image/"):
+		return &waProto.Message{
+			ImageMessage: &waProto.ImageMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				Mimetype:      proto.String(payload.mimeType),
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    fileLength,
+				Caption:       proto.String(cmd.Text),
+			},
+		}, nil
+
+	case strings.HasPrefix(payload.mimeType, "video/"):
+		return &waProto.Message{
+			VideoMessage: &waProto.VideoMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				Mimetype:      proto.String(payload.mimeType),
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    fileLength,
+				Caption:       proto.String(cmd.Text),
+			},
+		}, nil
+
+	case strings.HasPrefix(payload.mimeType, "audio/"):
+		return &waProto.Message{
+			AudioMessage: &waProto.AudioMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				Mimetype:      proto.String(payload.mimeType),
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    fileLength,
+			},
+		}, nil
+
+	default:
+		return &waProto.Message{
+			DocumentMessage: &waProto.DocumentMessage{
+				URL:           proto.String(uploaded.URL),
+				DirectPath:    proto.String(uploaded.DirectPath),
+				MediaKey:      uploaded.MediaKey,
+				Mimetype:      proto.String(payload.mimeType),
+				FileEncSHA256: uploaded.FileEncSHA256,
+				FileSHA256:    uploaded.FileSHA256,
+				FileLength:    fileLength,
+				FileName:      proto.String(documentFileName(cmd.Media, payload.mimeType)),
+				Caption:       proto.String(cmd.Text),
+			},
+		}, nil
+	}
+}
+
+// documentFileName picks a name for an outbound document. ref is only
+// usable as a filename when it's a local path — a data-URL has no filename
+// of its own, so fall back to a generic name built from its MIME type.
+func documentFileName(ref, mimeType string) string {
+	if strings.HasPrefix(ref, "data:") {
+		name := "file"
+		if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+			name += exts[0]
+		}
+		return name
+	}
+	return filepath.Base(ref)
+}
+
+// ── Media Download (inbound) ─────────────────────────────────────────────────
+
+// downloadableMedia returns the whatsmeow DownloadableMessage embedded in
+// msg, plus a short media type label and file extension, or ok=false if the
+// message carries no media.
+func downloadableMedia(msg *waProto.Message) (media whatsmeow.DownloadableMessage, mediaType, ext string, ok bool) {
+	switch {
+	case msg.GetImageMessage() != nil:
+		return msg.GetImageMessage(), "image", extensionFor(msg.GetImageMessage().GetMimetype(), "jpg"), true
+	case msg.GetVideoMessage() != nil:
+		return msg.GetVideoMessage(), "video", extensionFor(msg.GetVideoMessage().GetMimetype(), "mp4"), true
+	case msg.GetAudioMessage() != nil:
+		return msg.GetAudioMessage(), "audio", extensionFor(msg.GetAudioMessage().GetMimetype(), "ogg"), true
+	case msg.GetStickerMessage() != nil:
+		return msg.GetStickerMessage(), "sticker", extensionFor(msg.GetStickerMessage().GetMimetype(), "webp"), true
+	case msg.GetDocumentMessage() != nil:
+		return msg.GetDocumentMessage(), "document", extensionFor(msg.GetDocumentMessage().GetMimetype(), "bin"), true
+	default:
+		return nil, "", "", false
+	}
+}
+
+func extensionFor(mimeType, fallback string) string {
+	exts, err := mime.ExtensionsByType(mimeType)
+	if err != nil || len(exts) == 0 {
+		return fallback
+	}
+	return strings.TrimPrefix(exts[0], ".")
+}
+
+// downloadMedia fetches the media attached to job.message and writes it
+// under <authDir>/media/<chatJID>/<msgID>.<ext>, then emits a follow-up
+// event so Python can attach the file to the message. For a live message
+// that message was already emitted; for a history-sync message this event
+// can arrive before the history_messages batch that carries it.
+func downloadMedia(job mediaDownloadJob) {
+	media, mediaType, ext, ok := downloadableMedia(job.message)
+	if !ok {
+		return
+	}
+
+	data, err := client.Download(context.Background(), media)
+	if err != nil {
+		logErr(fmt.Sprintf("Media download failed for %s: %v", job.id, err))
+		return
+	}
+
+	dir := filepath.Join(authDir, "media", job.chatJID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		logErr(fmt.Sprintf("Failed to create media dir %s: %v", dir, err))
+		return
+	}
+
+	path := filepath.Join(dir, job.id+"."+ext)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		logErr(fmt.Sprintf("Failed to write media %s: %v", path, err))
+		return
+	}
+
+	if histStore != nil {
+		if err := histStore.UpdateMediaPath(job.id, job.chatJID, path); err != nil {
+			logErr(fmt.Sprintf("Failed to persist media path for %s: %v", job.id, err))
+		}
+	}
+
+	emit(map[string]interface{}{
+		"type":      "message_media",
+		"id":        job.id,
+		"from":      job.chatJID,
+		"mediaPath": path,
+		"mediaType": mediaType,
+	})
+}
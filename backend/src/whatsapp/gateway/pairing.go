@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow"
+)
+
+// ── Phone-number pairing (alternative to QR) ────────────────────────────────
+
+// pairCodeTimeout mirrors the window whatsmeow's servers keep a pairing
+// code valid for.
+const pairCodeTimeout = 160 * time.Second
+
+var pairSuccess = make(chan struct{}, 1)
+
+// notifyPairSuccess wakes any pending pairPhone timeout wait. Safe to call
+// even when no pairing is in flight.
+func notifyPairSuccess() {
+	select {
+	case pairSuccess <- struct{}{}:
+	default:
+	}
+}
+
+// pairPhone runs whatsmeow's 8-letter pairing-code flow against phone (E164,
+// e.g. "+15551234567") and emits the resulting code to Python. Used when QR
+// scanning isn't practical, e.g. headless deployments. If pairing doesn't
+// complete (events.PairSuccess) within pairCodeTimeout, a pair_timeout event
+// is emitted instead.
+func pairPhone(ctx context.Context, phone string) {
+	code, err := client.PairPhone(ctx, phone, true, whatsmeow.PairClientChrome, "Orbit AI")
+	if err != nil {
+		logErr(fmt.Sprintf("Phone pairing failed: %v", err))
+		emit(map[string]interface{}{
+			"type":    "error",
+			"message": fmt.Sprintf("pair_phone failed: %v", err),
+		})
+		return
+	}
+
+	emit(map[string]string{"type": "pair_code", "data": code})
+
+	select {
+	case <-pairSuccess:
+		// Paired — events.Connected will follow shortly.
+	case <-time.After(pairCodeTimeout):
+		logErr("Phone pairing code timed out")
+		emit(map[string]interface{}{"type": "pair_timeout"})
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"go.mau.fi/whatsmeow/types"
+	"go.mau.fi/whatsmeow/types/events"
+
+	"github.com/vishal124942/orbit_ai/backend/src/whatsapp/gateway/store"
+)
+
+// ── Presence / receipt / typing events (outbound to Python) ────────────────
+
+func handleReceipt(evt *events.Receipt) {
+	if histStore != nil {
+		for _, id := range evt.MessageIDs {
+			if err := histStore.SaveReceipt(store.Receipt{
+				MessageID: id,
+				ChatJID:   evt.Chat.String(),
+				SenderJID: evt.Sender.String(),
+				Type:      string(evt.Type),
+				Timestamp: evt.Timestamp.Unix(),
+			}); err != nil {
+				logErr(fmt.Sprintf("Failed to persist receipt for %s: %v", id, err))
+			}
+		}
+	}
+
+	emit(map[string]interface{}{
+		"type":      "receipt",
+		"id":        evt.MessageIDs,
+		"from":      evt.Chat.String(),
+		"sender":    evt.Sender.String(),
+		"isGroup":   evt.IsGroup,
+		"fromMe":    evt.IsFromMe,
+		"status":    string(evt.Type),
+		"timestamp": evt.Timestamp.Unix(),
+	})
+}
+
+func handlePresence(evt *events.Presence) {
+	emit(map[string]interface{}{
+		"type":     "presence",
+		"from":     evt.From.String(),
+		"status":   presenceStatus(evt.Unavailable),
+		"lastSeen": evt.LastSeen.Unix(),
+	})
+}
+
+func presenceStatus(unavailable bool) string {
+	if unavailable {
+		return "offline"
+	}
+	return "online"
+}
+
+func handleChatPresence(evt *events.ChatPresence) {
+	emit(map[string]interface{}{
+		"type":   "chat_presence",
+		"from":   evt.Chat.String(),
+		"sender": evt.Sender.String(),
+		"state":  string(evt.State),
+		"media":  string(evt.Media),
+	})
+}
+
+func handleUndecryptableMessage(evt *events.UndecryptableMessage) {
+	emit(map[string]interface{}{
+		"type": "undecryptable_message",
+		"id":   evt.Info.ID,
+		"from": evt.Info.Chat.String(),
+	})
+}
+
+func handleGroupInfo(evt *events.GroupInfo) {
+	join := make([]string, len(evt.Join))
+	for i, jid := range evt.Join {
+		join[i] = jid.String()
+	}
+	leave := make([]string, len(evt.Leave))
+	for i, jid := range evt.Leave {
+		leave[i] = jid.String()
+	}
+	promote := make([]string, len(evt.Promote))
+	for i, jid := range evt.Promote {
+		promote[i] = jid.String()
+	}
+	demote := make([]string, len(evt.Demote))
+	for i, jid := range evt.Demote {
+		demote[i] = jid.String()
+	}
+
+	data := map[string]interface{}{
+		"join":    join,
+		"leave":   leave,
+		"promote": promote,
+		"demote":  demote,
+	}
+	if evt.Name != nil {
+		data["name"] = evt.Name.Name
+	}
+	if evt.Topic != nil {
+		data["topic"] = evt.Topic.Topic
+	}
+
+	emit(map[string]interface{}{
+		"type":    "group_info",
+		"from":    evt.JID.String(),
+		"data":    data,
+		"isGroup": true,
+	})
+}
+
+func handleCallOffer(evt *events.CallOffer) {
+	emit(map[string]interface{}{
+		"type": "call_offer",
+		"from": evt.From.String(),
+		"id":   evt.CallID,
+	})
+}
+
+func handleCallTerminate(evt *events.CallTerminate) {
+	emit(map[string]interface{}{
+		"type":    "call_terminate",
+		"from":    evt.From.String(),
+		"id":      evt.CallID,
+		"message": evt.Reason,
+	})
+}
+
+func handlePictureEvent(evt *events.Picture) {
+	emit(map[string]interface{}{
+		"type":   "picture",
+		"from":   evt.JID.String(),
+		"id":     evt.PictureID,
+		"status": pictureStatus(evt.Remove),
+	})
+}
+
+func pictureStatus(removed bool) string {
+	if removed {
+		return "removed"
+	}
+	return "changed"
+}
+
+// ── Presence / receipt / typing commands (inbound from Python) ─────────────
+
+func handleSubscribePresence(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+	return client.SubscribePresence(context.Background(), jid)
+}
+
+func handleSendPresence(cmd InCommand) error {
+	var state types.Presence
+	switch cmd.Text {
+	case "available":
+		state = types.PresenceAvailable
+	case "unavailable":
+		state = types.PresenceUnavailable
+	default:
+		return fmt.Errorf("unknown presence state %q", cmd.Text)
+	}
+	return client.SendPresence(context.Background(), state)
+}
+
+func handleSendTyping(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+
+	state := types.ChatPresenceComposing
+	media := types.ChatPresenceMediaText
+	switch cmd.Text {
+	case "paused", "stop":
+		state = types.ChatPresencePaused
+	case "recording":
+		media = types.ChatPresenceMediaAudio
+	}
+
+	return client.SendChatPresence(context.Background(), jid, state, media)
+}
+
+func handleMarkRead(cmd InCommand) error {
+	jid, err := parseJID(cmd.To)
+	if err != nil {
+		return err
+	}
+
+	ids := cmd.MessageIDs
+	if cmd.MessageID != "" {
+		ids = append(ids, cmd.MessageID)
+	}
+	if len(ids) == 0 {
+		return fmt.Errorf("mark_read requires messageId or messageIds")
+	}
+
+	// In a group, the receipt's sender must be the message's original
+	// participant JID, not the group JID — the chat and sender only
+	// coincide in 1:1 chats.
+	sender := jid
+	if cmd.Sender != "" {
+		sender, err = parseJID(cmd.Sender)
+		if err != nil {
+			return err
+		}
+	}
+
+	return client.MarkRead(context.Background(), ids, time.Now(), jid, sender)
+}
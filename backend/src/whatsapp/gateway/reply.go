@@ -0,0 +1,63 @@
+package main
+
+import (
+	waProto "go.mau.fi/whatsmeow/binary/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// ── Reply / quoted-message and mention support ──────────────────────────────
+
+func hasReplyContext(cmd InCommand) bool {
+	return cmd.QuotedID != "" || len(cmd.Mentions) > 0
+}
+
+// buildReplyContextMessage wraps cmd.Text in an ExtendedTextMessage carrying
+// a ContextInfo, so outbound sends can quote a prior message and/or @mention
+// participants the way a plain Conversation message can't.
+func buildReplyContextMessage(cmd InCommand) *waProto.Message {
+	ctx := &waProto.ContextInfo{}
+
+	if cmd.QuotedID != "" {
+		ctx.StanzaID = proto.String(cmd.QuotedID)
+		ctx.Participant = proto.String(cmd.QuotedFrom)
+		ctx.QuotedMessage = &waProto.Message{
+			Conversation: proto.String(cmd.QuotedText),
+		}
+	}
+
+	if len(cmd.Mentions) > 0 {
+		mentioned := make([]string, len(cmd.Mentions))
+		for i, m := range cmd.Mentions {
+			jid, err := parseJID(m)
+			if err != nil {
+				mentioned[i] = m
+				continue
+			}
+			mentioned[i] = jid.String()
+		}
+		ctx.MentionedJID = mentioned
+	}
+
+	return &waProto.Message{
+		ExtendedTextMessage: &waProto.ExtendedTextMessage{
+			Text:        proto.String(cmd.Text),
+			ContextInfo: ctx,
+		},
+	}
+}
+
+// extractReplyContext reads the ContextInfo off an inbound ExtendedTextMessage
+// (if any) so Python can render threads and mentions.
+func extractReplyContext(msg *waProto.Message) (quotedID, quotedFrom string, mentions []string) {
+	ext := msg.GetExtendedTextMessage()
+	if ext == nil {
+		return "", "", nil
+	}
+
+	info := ext.GetContextInfo()
+	if info == nil {
+		return "", "", nil
+	}
+
+	return info.GetStanzaID(), info.GetParticipant(), info.GetMentionedJID()
+}
@@ -0,0 +1,266 @@
+// Package store persists chat history in a second SQLite database next to
+// whatsmeow's own auth store, so reconnecting or restarting the gateway
+// doesn't force Python to rebuild state from scratch.
+package store
+
+import (
+	"database/sql"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS contacts (
+	jid       TEXT PRIMARY KEY,
+	name      TEXT,
+	notify    TEXT,
+	push_name TEXT,
+	is_group  INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS chats (
+	jid             TEXT PRIMARY KEY,
+	name            TEXT,
+	last_message_ts INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS messages (
+	id             TEXT NOT NULL,
+	chat_jid       TEXT NOT NULL,
+	sender_jid     TEXT,
+	from_me        INTEGER NOT NULL DEFAULT 0,
+	text           TEXT,
+	media_path     TEXT,
+	timestamp      INTEGER NOT NULL,
+	quoted_id      TEXT,
+	reactions_json TEXT,
+	PRIMARY KEY (id, chat_jid)
+);
+CREATE INDEX IF NOT EXISTS idx_messages_chat_ts ON messages(chat_jid, timestamp);
+
+CREATE TABLE IF NOT EXISTS receipts (
+	message_id TEXT NOT NULL,
+	chat_jid   TEXT NOT NULL,
+	sender_jid TEXT NOT NULL,
+	type       TEXT NOT NULL,
+	timestamp  INTEGER NOT NULL,
+	PRIMARY KEY (message_id, chat_jid, sender_jid, type)
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS messages_fts USING fts5(
+	id UNINDEXED,
+	chat_jid UNINDEXED,
+	text
+);
+`
+
+// Store wraps the gateway's history database (contacts/chats/messages/
+// receipts), separate from whatsmeow's own auth database.
+type Store struct {
+	db *sql.DB
+}
+
+// Open opens (creating if necessary) the history database at path, with WAL
+// and foreign keys enabled to match the auth store's pragmas.
+func Open(path string) (*Store, error) {
+	db, err := sql.Open("sqlite3", fmt.Sprintf("file:%s?_journal_mode=WAL&_foreign_keys=on", path))
+	if err != nil {
+		return nil, fmt.Errorf("open history db: %v", err)
+	}
+
+	if _, err := db.Exec(schema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrate history db: %v", err)
+	}
+
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Contact is the persisted form of a contact or group.
+type Contact struct {
+	JID      string
+	Name     string
+	Notify   string
+	PushName string
+	IsGroup  bool
+}
+
+func (s *Store) UpsertContact(c Contact) error {
+	_, err := s.db.Exec(`
+		INSERT INTO contacts (jid, name, notify, push_name, is_group)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(jid) DO UPDATE SET
+			name = excluded.name,
+			notify = excluded.notify,
+			push_name = excluded.push_name,
+			is_group = excluded.is_group
+	`, c.JID, c.Name, c.Notify, c.PushName, c.IsGroup)
+	return err
+}
+
+// Message is the persisted form of a chat message.
+type Message struct {
+	ID            string
+	ChatJID       string
+	SenderJID     string
+	FromMe        bool
+	Text          string
+	MediaPath     string
+	Timestamp     int64
+	QuotedID      string
+	ReactionsJSON string
+}
+
+// SaveMessage upserts m. Messages routinely get re-saved — a live message is
+// persisted in handleMessage and then reappears in a HistorySync batch, and
+// whatsmeow redelivers on reconnect — so both the row upsert and the FTS
+// index update below must be idempotent. The four writes run in one
+// transaction so a crash or an interleaved SaveReceipt/UpdateMediaPath can't
+// leave messages_fts out of sync with messages.
+func (s *Store) SaveMessage(m Message) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`
+		INSERT INTO messages (id, chat_jid, sender_jid, from_me, text, media_path, timestamp, quoted_id, reactions_json)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(id, chat_jid) DO UPDATE SET
+			sender_jid = COALESCE(NULLIF(excluded.sender_jid, ''), messages.sender_jid),
+			from_me = excluded.from_me,
+			text = excluded.text,
+			media_path = excluded.media_path,
+			timestamp = excluded.timestamp,
+			quoted_id = excluded.quoted_id,
+			reactions_json = excluded.reactions_json
+	`, m.ID, m.ChatJID, m.SenderJID, m.FromMe, m.Text, m.MediaPath, m.Timestamp, m.QuotedID, m.ReactionsJSON)
+	if err != nil {
+		return err
+	}
+
+	// messages_fts is keyed by the messages row's rowid, which an upsert
+	// leaves unchanged — clear any prior entry before re-inserting so a
+	// re-save doesn't hit a duplicate-rowid constraint error.
+	_, err = tx.Exec(`
+		DELETE FROM messages_fts WHERE rowid = (SELECT rowid FROM messages WHERE id = ? AND chat_jid = ?)
+	`, m.ID, m.ChatJID)
+	if err != nil {
+		return fmt.Errorf("clear stale search index for message: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO messages_fts (rowid, id, chat_jid, text)
+		SELECT rowid, id, chat_jid, ? FROM messages WHERE id = ? AND chat_jid = ?
+	`, m.Text, m.ID, m.ChatJID)
+	if err != nil {
+		return fmt.Errorf("index message for search: %v", err)
+	}
+
+	_, err = tx.Exec(`
+		INSERT INTO chats (jid, last_message_ts) VALUES (?, ?)
+		ON CONFLICT(jid) DO UPDATE SET last_message_ts = excluded.last_message_ts
+		WHERE excluded.last_message_ts > chats.last_message_ts
+	`, m.ChatJID, m.Timestamp)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UpdateMediaPath fills in media_path for a message saved earlier, used
+// once an async media download completes.
+func (s *Store) UpdateMediaPath(id, chatJID, mediaPath string) error {
+	_, err := s.db.Exec(`UPDATE messages SET media_path = ? WHERE id = ? AND chat_jid = ?`, mediaPath, id, chatJID)
+	return err
+}
+
+// Receipt is the persisted form of a delivery/read/played receipt.
+type Receipt struct {
+	MessageID string
+	ChatJID   string
+	SenderJID string
+	Type      string
+	Timestamp int64
+}
+
+func (s *Store) SaveReceipt(r Receipt) error {
+	_, err := s.db.Exec(`
+		INSERT OR REPLACE INTO receipts (message_id, chat_jid, sender_jid, type, timestamp)
+		VALUES (?, ?, ?, ?, ?)
+	`, r.MessageID, r.ChatJID, r.SenderJID, r.Type, r.Timestamp)
+	return err
+}
+
+// History returns up to limit messages for chatJID older than before
+// (unix seconds; 0 or less means no cutoff, i.e. the newest messages),
+// newest first.
+func (s *Store) History(chatJID string, before int64, limit int) ([]Message, error) {
+	if before <= 0 {
+		before = 1<<63 - 1
+	}
+
+	rows, err := s.db.Query(`
+		SELECT id, chat_jid, sender_jid, from_me, text, media_path, timestamp, quoted_id, reactions_json
+		FROM messages
+		WHERE chat_jid = ? AND timestamp < ?
+		ORDER BY timestamp DESC
+		LIMIT ?
+	`, chatJID, before, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+// Search runs an FTS5 query over message text, optionally scoped to a
+// single chat.
+func (s *Store) Search(query, chatJID string) ([]Message, error) {
+	var rows *sql.Rows
+	var err error
+
+	if chatJID != "" {
+		rows, err = s.db.Query(`
+			SELECT m.id, m.chat_jid, m.sender_jid, m.from_me, m.text, m.media_path, m.timestamp, m.quoted_id, m.reactions_json
+			FROM messages_fts f
+			JOIN messages m ON m.id = f.id AND m.chat_jid = f.chat_jid
+			WHERE f.text MATCH ? AND m.chat_jid = ?
+			ORDER BY m.timestamp DESC
+		`, query, chatJID)
+	} else {
+		rows, err = s.db.Query(`
+			SELECT m.id, m.chat_jid, m.sender_jid, m.from_me, m.text, m.media_path, m.timestamp, m.quoted_id, m.reactions_json
+			FROM messages_fts f
+			JOIN messages m ON m.id = f.id AND m.chat_jid = f.chat_jid
+			WHERE f.text MATCH ?
+			ORDER BY m.timestamp DESC
+		`, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanMessages(rows)
+}
+
+func scanMessages(rows *sql.Rows) ([]Message, error) {
+	var messages []Message
+	for rows.Next() {
+		var m Message
+		if err := rows.Scan(&m.ID, &m.ChatJID, &m.SenderJID, &m.FromMe, &m.Text, &m.MediaPath, &m.Timestamp, &m.QuotedID, &m.ReactionsJSON); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ── Transport ────────────────────────────────────────────────────────────────
+//
+// Transport abstracts how OutEvents leave the gateway and InCommands arrive.
+// The original protocol was one JSON object per newline on stdout/stdin,
+// serialized with outMu; under a large history sync that becomes a
+// bottleneck, and any embedded newline in message text or base64 media
+// corrupts the stream. Both implementations here instead use a 4-byte
+// big-endian length prefix followed by the raw JSON payload.
+
+type Transport interface {
+	// Send queues an outbound event. Non-critical event types (e.g.
+	// presence) are dropped under backpressure rather than blocking.
+	Send(v interface{})
+	// Commands yields decoded inbound commands. The stdio transport closes
+	// it when stdin is exhausted; the socket transport keeps it open across
+	// client reconnects and never closes it.
+	Commands() <-chan InCommand
+	Close() error
+}
+
+// nonCriticalEventTypes are safe to drop under backpressure: Python can
+// always re-derive current presence, but a dropped message or ack would
+// desync chat state.
+var nonCriticalEventTypes = map[string]bool{
+	"presence":      true,
+	"chat_presence": true,
+}
+
+func eventType(data []byte) string {
+	var head struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(data, &head)
+	return head.Type
+}
+
+// ── Framed codec (shared by stdio and socket transports) ───────────────────
+
+const outQueueSize = 1024
+
+type framedTransport struct {
+	w        io.Writer
+	wMu      sync.Mutex
+	cmds     chan InCommand
+	outQueue chan []byte
+	done     chan struct{}
+}
+
+func newFramedTransport(r io.Reader, w io.Writer) *framedTransport {
+	t := &framedTransport{
+		w:        w,
+		cmds:     make(chan InCommand, 256),
+		outQueue: make(chan []byte, outQueueSize),
+		done:     make(chan struct{}),
+	}
+	go t.readLoop(r)
+	go t.writeLoop()
+	return t
+}
+
+func (t *framedTransport) Commands() <-chan InCommand { return t.cmds }
+
+func (t *framedTransport) Send(v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return
+	}
+
+	select {
+	case t.outQueue <- data:
+		return
+	default:
+	}
+
+	if nonCriticalEventTypes[eventType(data)] {
+		// Drop-oldest: make room rather than blocking the event loop.
+		select {
+		case <-t.outQueue:
+		default:
+		}
+		select {
+		case t.outQueue <- data:
+		default:
+		}
+		return
+	}
+
+	// Critical event — block until the writer catches up.
+	select {
+	case t.outQueue <- data:
+	case <-t.done:
+	}
+}
+
+func (t *framedTransport) writeLoop() {
+	for {
+		select {
+		case data := <-t.outQueue:
+			if err := t.writeFrame(data); err != nil {
+				logErr(fmt.Sprintf("Transport write error: %v", err))
+				return
+			}
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *framedTransport) writeFrame(data []byte) error {
+	t.wMu.Lock()
+	defer t.wMu.Unlock()
+
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(data)))
+	if _, err := t.w.Write(lenBuf[:]); err != nil {
+		return err
+	}
+	_, err := t.w.Write(data)
+	return err
+}
+
+func (t *framedTransport) readLoop(r io.Reader) {
+	defer close(t.cmds)
+	br := bufio.NewReader(r)
+
+	for {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(br, lenBuf[:]); err != nil {
+			return
+		}
+		n := binary.BigEndian.Uint32(lenBuf[:])
+
+		payload := make([]byte, n)
+		if _, err := io.ReadFull(br, payload); err != nil {
+			return
+		}
+
+		var cmd InCommand
+		if err := json.Unmarshal(payload, &cmd); err != nil {
+			logErr(fmt.Sprintf("Invalid command frame: %v", err))
+			continue
+		}
+
+		select {
+		case t.cmds <- cmd:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *framedTransport) Close() error {
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	return nil
+}
+
+// ── Stdio transport ──────────────────────────────────────────────────────────
+
+func newStdioTransport() Transport {
+	return newFramedTransport(os.Stdin, os.Stdout)
+}
+
+// ── Socket transport ─────────────────────────────────────────────────────────
+
+// socketTransport listens on a Unix or TCP socket and forwards to whichever
+// connection is currently accepted, so a reconnecting Python bridge doesn't
+// require restarting the gateway. Critical events sent before any client has
+// connected are buffered (see pending) and replayed to the first connection.
+// pendingBacklogSize bounds how many pre-connection critical events
+// socketTransport will hold for the first client to connect — just enough
+// to cover startup pairing (qr/pair_code), not a general outbox.
+const pendingBacklogSize = 16
+
+type socketTransport struct {
+	listener net.Listener
+	cmds     chan InCommand
+	done     chan struct{}
+
+	mu      sync.Mutex
+	current *framedTransport
+	pending []interface{}
+}
+
+// newSocketTransport parses addr of the form "unix:///tmp/orbit.sock" or
+// "tcp://host:port" and starts listening.
+func newSocketTransport(addr string) (Transport, error) {
+	network, address, ok := strings.Cut(addr, "://")
+	if !ok {
+		return nil, fmt.Errorf("invalid --listen address %q, want unix://path or tcp://host:port", addr)
+	}
+
+	if network == "unix" {
+		_ = os.Remove(address)
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen %s: %v", addr, err)
+	}
+
+	t := &socketTransport{
+		listener: listener,
+		cmds:     make(chan InCommand, 256),
+		done:     make(chan struct{}),
+	}
+	go t.acceptLoop()
+	return t, nil
+}
+
+func (t *socketTransport) acceptLoop() {
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.done:
+			default:
+				logErr(fmt.Sprintf("Socket accept error: %v", err))
+			}
+			return
+		}
+
+		logErr(fmt.Sprintf("Socket transport: client connected from %s", conn.RemoteAddr()))
+		ft := newFramedTransport(conn, conn)
+
+		t.mu.Lock()
+		t.current = ft
+		pending := t.pending
+		t.pending = nil
+		t.mu.Unlock()
+
+		// Replay anything buffered before this connection existed — e.g. the
+		// qr/pair_code events a first-time --listen session emits at
+		// startup, before the Python bridge has dialed in.
+		for _, v := range pending {
+			ft.Send(v)
+		}
+
+		go t.forwardCommands(ft)
+	}
+}
+
+func (t *socketTransport) forwardCommands(ft *framedTransport) {
+	for cmd := range ft.Commands() {
+		select {
+		case t.cmds <- cmd:
+		case <-t.done:
+			return
+		}
+	}
+}
+
+func (t *socketTransport) Commands() <-chan InCommand { return t.cmds }
+
+func (t *socketTransport) Send(v interface{}) {
+	t.mu.Lock()
+	ft := t.current
+	if ft == nil {
+		// No client has connected yet. Buffer critical events (e.g. the
+		// startup qr/pair_code for first-time pairing) so they aren't lost
+		// before the Python bridge dials in; non-critical ones are fine to
+		// drop outright since they'd be dropped under backpressure anyway.
+		data, err := json.Marshal(v)
+		if err == nil && !nonCriticalEventTypes[eventType(data)] {
+			t.pending = append(t.pending, v)
+			if len(t.pending) > pendingBacklogSize {
+				t.pending = t.pending[1:]
+			}
+		}
+		t.mu.Unlock()
+		return
+	}
+	t.mu.Unlock()
+	ft.Send(v)
+}
+
+func (t *socketTransport) Close() error {
+	select {
+	case <-t.done:
+	default:
+		close(t.done)
+	}
+	return t.listener.Close()
+}
+
+// ── Global wiring ────────────────────────────────────────────────────────────
+
+var transport Transport
+
+func emit(v interface{}) {
+	transport.Send(v)
+}